@@ -0,0 +1,257 @@
+package ssh2docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/google/shlex"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// runAdminConsole replaces the plain /bin/bash shell previously offered to
+// Server.LocalUser with an operations console for inspecting and managing
+// active ssh2docker sessions and containers. Every command is logged and
+// only reachable because the caller already authenticated as LocalUser. It
+// blocks until the operator quits, so the caller should run it in a
+// goroutine the same way runContainerShell's exec session runs in one.
+func (c *Client) runAdminConsole(channel ssh.Channel) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	term := terminal.NewTerminal(channel, "ssh2docker> ")
+
+	for {
+		line, err := term.ReadLine()
+		if err != nil {
+			break
+		}
+
+		args, err := shlex.Split(line)
+		if err != nil || len(args) == 0 {
+			continue
+		}
+
+		log.Infof("admin command from %s: %v", c.ClientID, args)
+
+		if c.dispatchAdminCommand(ctx, term, channel, args) {
+			break
+		}
+	}
+
+	channel.Close()
+}
+
+// dispatchAdminCommand runs one admin command, returning true once the
+// console should exit ("quit"). ctx is cancelled when the console exits, so
+// that any command left streaming in the background (e.g. "logs") unwinds
+// instead of leaking.
+func (c *Client) dispatchAdminCommand(ctx context.Context, term *terminal.Terminal, channel ssh.Channel, args []string) bool {
+	switch args[0] {
+	case "list":
+		c.adminList(ctx, term)
+
+	case "ps":
+		c.adminPS(ctx, term)
+
+	case "kill":
+		if len(args) != 2 {
+			fmt.Fprintln(term, "usage: kill <id>")
+			break
+		}
+		if err := c.Server.Docker.ContainerKill(ctx, args[1], "KILL"); err != nil {
+			fmt.Fprintf(term, "kill failed: %v\n", err)
+		}
+
+	case "attach":
+		if len(args) != 2 {
+			fmt.Fprintln(term, "usage: attach <id>")
+			break
+		}
+		c.adminAttach(ctx, channel, args[1])
+
+	case "logs":
+		if len(args) != 2 {
+			fmt.Fprintln(term, "usage: logs <id>")
+			break
+		}
+		c.adminLogs(ctx, term, args[1])
+
+	case "config":
+		c.adminConfig(term, args[1:])
+
+	case "kick":
+		if len(args) != 2 {
+			fmt.Fprintln(term, "usage: kick <clientID>")
+			break
+		}
+		c.Server.KickClient(args[1])
+
+	case "reload-hooks":
+		c.Server.ReloadHooks()
+		fmt.Fprintln(term, "hooks reloaded")
+
+	case "quit":
+		return true
+
+	default:
+		fmt.Fprintf(term, "unknown command: %q\n", args[0])
+	}
+
+	return false
+}
+
+// adminList shows the active ssh2docker containers (i.e. those carrying
+// the "ssh2docker" label), across all users.
+func (c *Client) adminList(ctx context.Context, term *terminal.Terminal) {
+	f := filters.NewArgs()
+	f.Add("label", "ssh2docker")
+
+	containers, err := c.Server.Docker.ContainerList(ctx, types.ContainerListOptions{Filters: f})
+	if err != nil {
+		fmt.Fprintf(term, "list failed: %v\n", err)
+		return
+	}
+
+	for _, ctr := range containers {
+		fmt.Fprintf(term, "%.12s  %-30s  user=%s image=%s\n", ctr.ID, ctr.Image, ctr.Labels["user"], ctr.Labels["image"])
+	}
+}
+
+// adminPS shows every container on the daemon, ssh2docker-managed or not.
+func (c *Client) adminPS(ctx context.Context, term *terminal.Terminal) {
+	containers, err := c.Server.Docker.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		fmt.Fprintf(term, "ps failed: %v\n", err)
+		return
+	}
+
+	for _, ctr := range containers {
+		fmt.Fprintf(term, "%.12s  %-30s  %s\n", ctr.ID, ctr.Image, ctr.Status)
+	}
+}
+
+// adminAttach hands the raw channel off to a TTY exec session in the
+// target container until it exits, then returns control to the console.
+func (c *Client) adminAttach(ctx context.Context, channel ssh.Channel, id string) {
+	hijacked, _, err := c.attachExec(ctx, id, []string{c.Server.DefaultShell}, true)
+	if err != nil {
+		fmt.Fprintf(channel, "attach failed: %v\n\r", err)
+		return
+	}
+
+	fmt.Fprintf(channel, "attached to %s, exit the shell to return to the console\n\r", id)
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(hijacked.Conn, channel)
+		close(done)
+	}()
+
+	io.Copy(channel, hijacked.Reader)
+	hijacked.Close()
+	<-done
+}
+
+// adminLogs follows a container's combined stdout/stderr in the background,
+// so the console keeps accepting commands while logs stream; the follow
+// stops when ctx is cancelled (console exit) or the container goes away.
+func (c *Client) adminLogs(ctx context.Context, term *terminal.Terminal, id string) {
+	reader, err := c.Server.Docker.ContainerLogs(ctx, id, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
+	if err != nil {
+		fmt.Fprintf(term, "logs failed: %v\n", err)
+		return
+	}
+
+	go func() {
+		defer reader.Close()
+		io.Copy(term, reader)
+	}()
+}
+
+// adminConfig implements "config get/set <clientID> <key> [value]" against
+// Server.ClientConfigs.
+func (c *Client) adminConfig(term *terminal.Terminal, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(term, "usage: config get|set <clientID> <key> [value]")
+		return
+	}
+
+	action, clientID := args[0], args[1]
+	cfg, found := c.Server.ClientConfigs[clientID]
+	if !found {
+		fmt.Fprintf(term, "unknown client %q\n", clientID)
+		return
+	}
+
+	switch action {
+	case "get":
+		if len(args) != 3 {
+			fmt.Fprintln(term, "usage: config get <clientID> <key>")
+			return
+		}
+		fmt.Fprintln(term, configField(cfg, args[2]))
+
+	case "set":
+		if len(args) != 4 {
+			fmt.Fprintln(term, "usage: config set <clientID> <key> <value>")
+			return
+		}
+		if err := setConfigField(cfg, args[2], args[3]); err != nil {
+			fmt.Fprintf(term, "set failed: %v\n", err)
+		}
+
+	default:
+		fmt.Fprintf(term, "unknown config action %q\n", action)
+	}
+}
+
+// configField reads one of the ClientConfig fields exposed to the console by
+// name; "is-local" can be read here but setConfigField treats it as
+// read-only.
+func configField(cfg *ClientConfig, key string) string {
+	switch key {
+	case "image-name":
+		return cfg.ImageName
+	case "remote-user":
+		return cfg.RemoteUser
+	case "user":
+		return cfg.User
+	case "entrypoint":
+		return cfg.EntryPoint
+	case "allowed":
+		return strconv.FormatBool(cfg.Allowed)
+	case "is-local":
+		return strconv.FormatBool(cfg.IsLocal)
+	default:
+		return fmt.Sprintf("unknown key %q", key)
+	}
+}
+
+// setConfigField writes one of the mutable ClientConfig fields by name.
+func setConfigField(cfg *ClientConfig, key, value string) error {
+	switch key {
+	case "image-name":
+		cfg.ImageName = value
+	case "remote-user":
+		cfg.RemoteUser = value
+	case "user":
+		cfg.User = value
+	case "entrypoint":
+		cfg.EntryPoint = value
+	case "allowed":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		cfg.Allowed = b
+	default:
+		return fmt.Errorf("unknown or read-only key %q", key)
+	}
+	return nil
+}
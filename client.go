@@ -1,14 +1,12 @@
 package ssh2docker
 
 import (
+	"encoding/binary"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"strings"
-	"sync"
 	"syscall"
-	"time"
 
 	"github.com/apex/log"
 	"github.com/kr/pty"
@@ -30,6 +28,7 @@ type Client struct {
 	Pty, Tty   *os.File
 	Config     *ClientConfig
 	ClientID   string
+	ExecID     string
 }
 
 type ClientConfig struct {
@@ -46,6 +45,8 @@ type ClientConfig struct {
 	AuthenticationAttempts int                   `json:"authentication-attempts,omitempty"`
 	Allowed                bool                  `json:"allowed,omitempty"`
 	IsLocal                bool                  `json:"is_local,omitempty"`
+	AllowedForwardPorts    []string              `json:"allowed-forward-ports,omitempty"`
+	SFTPRootPath           string                `json:"sftp-root-path,omitempty"`
 }
 
 // NewClient initializes a new client
@@ -114,7 +115,12 @@ func (c *Client) HandleChannels() error {
 
 // HandleChannel handles one SSH channel
 func (c *Client) HandleChannel(newChannel ssh.NewChannel) error {
-	if newChannel.ChannelType() != "session" {
+	switch newChannel.ChannelType() {
+	case "session":
+		// handled below
+	case "direct-tcpip":
+		return c.HandleDirectTCPIP(newChannel)
+	default:
 		log.Debugf("Unknown channel type: %s", newChannel.ChannelType())
 		newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
 		return nil
@@ -142,6 +148,37 @@ func (c *Client) HandleChannel(newChannel ssh.NewChannel) error {
 	return nil
 }
 
+// runLocalExec runs a one-shot command locally for Server.LocalUser and
+// propagates its exit status back to channel.
+func (c *Client) runLocalExec(channel ssh.Channel, command string) {
+	cmd := exec.Command(c.Server.DefaultShell, "-c", command)
+	cmd.Env = c.Config.Env.List()
+	cmd.Stdout = channel
+	cmd.Stdin = channel
+	cmd.Stderr = channel.Stderr()
+
+	if err := cmd.Start(); err != nil {
+		log.Warnf("cmd.Start failed: %v", err)
+		return
+	}
+
+	go func() {
+		status := uint32(0)
+		if err := cmd.Wait(); err != nil {
+			if exitErr, isExitErr := err.(*exec.ExitError); isExitErr {
+				if ws, isWaitStatus := exitErr.Sys().(syscall.WaitStatus); isWaitStatus {
+					status = uint32(ws.ExitStatus())
+				}
+			} else {
+				log.Warnf("cmd.Wait failed: %v", err)
+			}
+		}
+
+		channel.SendRequest("exit-status", false, ssh.Marshal(&struct{ Status uint32 }{Status: status}))
+		channel.Close()
+	}()
+}
+
 // HandleChannelRequests handles channel requests
 func (c *Client) HandleChannelRequests(channel ssh.Channel, requests <-chan *ssh.Request) {
 	go func(in <-chan *ssh.Request) {
@@ -157,63 +194,6 @@ func (c *Client) HandleChannelRequests(channel ssh.Channel, requests <-chan *ssh
 				}
 				ok = true
 
-				var cmd *exec.Cmd
-				var err error
-
-				if c.Config.IsLocal {
-					cmd = exec.Command("/bin/bash")
-				} else {
-					// checking if a container already exists for this user
-					existingContainer := ""
-					if !c.Server.NoJoin {
-						cmd := exec.Command("docker", "ps", "--filter=label=ssh2docker", fmt.Sprintf("--filter=label=image=%s", c.Config.ImageName), fmt.Sprintf("--filter=label=user=%s", c.Config.RemoteUser), "--quiet", "--no-trunc")
-						cmd.Env = c.Config.Env.List()
-						buf, err := cmd.CombinedOutput()
-						if err != nil {
-							log.Warnf("docker ps ... failed: %v", err)
-							continue
-						}
-						existingContainer = strings.TrimSpace(string(buf))
-					}
-
-					// Opening Docker process
-					if existingContainer != "" {
-						// Attaching to an existing container
-						shell := c.Server.DefaultShell
-						if c.Config.EntryPoint != "" {
-							shell = c.Config.EntryPoint
-						}
-						args := []string{"exec", "-it", existingContainer, shell}
-						log.Debugf("Executing 'docker %s'", strings.Join(args, " "))
-						cmd = exec.Command("docker", args...)
-						cmd.Env = c.Config.Env.List()
-					} else {
-						// Creating and attaching to a new container
-						args := []string{"run"}
-						if len(c.Config.DockerRunArgs) > 0 {
-							args = append(args, c.Config.DockerRunArgs...)
-						} else {
-							args = append(args, c.Server.DockerRunArgs...)
-						}
-						args = append(args, "--label=ssh2docker", fmt.Sprintf("--label=user=%s", c.Config.RemoteUser), fmt.Sprintf("--label=image=%s", c.Config.ImageName))
-						if c.Config.User != "" {
-							args = append(args, "-u", c.Config.User)
-						}
-						if c.Config.EntryPoint != "" {
-							args = append(args, "--entrypoint", c.Config.EntryPoint)
-						}
-						args = append(args, c.Config.ImageName)
-						if c.Config.Command != nil {
-							args = append(args, c.Config.Command...)
-						} else {
-							args = append(args, c.Server.DefaultShell)
-						}
-						log.Debugf("Executing 'docker %s'", strings.Join(args, " "))
-						cmd = exec.Command("docker", args...)
-						cmd.Env = c.Config.Env.List()
-					}
-				}
-
 				if c.Server.Banner != "" {
 					banner := c.Server.Banner
 					banner = strings.Replace(banner, "\r", "", -1)
@@ -221,50 +201,53 @@ func (c *Client) HandleChannelRequests(channel ssh.Channel, requests <-chan *ssh
 					fmt.Fprintf(channel, "%s\n\r", banner)
 				}
 
-				cmd.Stdout = c.Tty
-				cmd.Stdin = c.Tty
-				cmd.Stderr = c.Tty
-				cmd.SysProcAttr = &syscall.SysProcAttr{
-					Setctty: true,
-					Setsid:  true,
+				if c.Config.IsLocal {
+					go c.runAdminConsole(channel)
+				} else if err := c.runContainerShell(channel); err != nil {
+					log.Warnf("runContainerShell failed: %v", err)
+					ok = false
 				}
 
-				err = cmd.Start()
-				if err != nil {
-					log.Warnf("cmd.Start failed: %v", err)
-					continue
+			case "exec":
+				if len(req.Payload) < 4 {
+					break
 				}
-
-				var once sync.Once
-				close := func() {
-					channel.Close()
-					log.Infof("Received disconnect from %s: disconnected by user", c.ClientID)
+				cmdLen := binary.BigEndian.Uint32(req.Payload[:4])
+				if uint64(4+cmdLen) > uint64(len(req.Payload)) {
+					break
 				}
+				command := string(req.Payload[4 : 4+cmdLen])
+				log.Debugf("HandleChannelRequests.req exec: %q", command)
+				ok = true
 
-				go func() {
-					io.Copy(channel, c.Pty)
-					once.Do(close)
-				}()
+				if c.Config.IsLocal {
+					c.runLocalExec(channel, command)
+				} else if err := c.runContainerExec(channel, command); err != nil {
+					log.Warnf("runContainerExec failed: %v", err)
+					ok = false
+				}
 
-				go func() {
-					io.Copy(c.Pty, channel)
-					once.Do(close)
-				}()
+			case "subsystem":
+				if len(req.Payload) < 4 {
+					break
+				}
+				nameLen := binary.BigEndian.Uint32(req.Payload[:4])
+				if uint64(4+nameLen) > uint64(len(req.Payload)) {
+					break
+				}
+				name := string(req.Payload[4 : 4+nameLen])
+				log.Debugf("HandleChannelRequests.req subsystem: %q", name)
 
-				go func() {
-					if err := cmd.Wait(); err != nil {
-						log.Warnf("cmd.Wait failed: %v", err)
-					}
-					once.Do(close)
-				}()
+				if name != "sftp" || c.Server.DisableSFTP {
+					break
+				}
+				ok = true
 
-			case "exec":
-				command := string(req.Payload)
-				log.Debugf("HandleChannelRequests.req exec: %q", command)
-				ok = false
+				go c.HandleSFTP(channel)
 
-				fmt.Fprintln(channel, "⚠️  ssh2docker: exec is not yet implemented. https://github.com/moul/ssh2docker/issues/51.")
-				time.Sleep(3 * time.Second)
+			case httpGatewayRequestType:
+				ok = true
+				go c.HandleHTTPGateway(channel)
 
 			case "pty-req":
 				ok = true
@@ -277,6 +260,7 @@ func (c *Client) HandleChannelRequests(channel ssh.Channel, requests <-chan *ssh
 			case "window-change":
 				w, h := ttyhelper.ParseDims(req.Payload)
 				ttyhelper.SetWinsize(c.Pty.Fd(), w, h)
+				c.resizeExec(w, h)
 				continue
 
 			case "env":
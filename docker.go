@@ -0,0 +1,223 @@
+package ssh2docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+	"golang.org/x/crypto/ssh"
+)
+
+// findJoinableContainer looks up a running container for the current
+// user/image pair through the Docker Engine API, respecting Server.NoJoin.
+// It replaces the `docker ps` shell-out previously used by the shell/exec
+// paths.
+func (c *Client) findJoinableContainer(ctx context.Context) (string, error) {
+	if c.Server.NoJoin {
+		return "", nil
+	}
+
+	f := filters.NewArgs()
+	f.Add("label", "ssh2docker")
+	f.Add("label", fmt.Sprintf("image=%s", c.Config.ImageName))
+	f.Add("label", fmt.Sprintf("user=%s", c.Config.RemoteUser))
+
+	containers, err := c.Server.Docker.ContainerList(ctx, types.ContainerListOptions{Filters: f})
+	if err != nil {
+		return "", fmt.Errorf("ContainerList failed: %v", err)
+	}
+	if len(containers) == 0 {
+		return "", nil
+	}
+	return containers[0].ID, nil
+}
+
+// createContainer creates and starts a new container for the current
+// client, with the ssh2docker/user/image labels and the client's image,
+// user, entrypoint, env and Command (DefaultShell when Command is empty).
+// DockerRunArgs, per client or server-wide, are not applied.
+func (c *Client) createContainer(ctx context.Context) (string, error) {
+	var entrypoint []string
+	if c.Config.EntryPoint != "" {
+		entrypoint = []string{c.Config.EntryPoint}
+	}
+
+	cmd := c.Config.Command
+	if len(cmd) == 0 {
+		cmd = []string{c.Server.DefaultShell}
+	}
+
+	config := &container.Config{
+		Image:      c.Config.ImageName,
+		Cmd:        cmd,
+		Entrypoint: entrypoint,
+		User:       c.Config.User,
+		Env:        c.Config.Env.List(),
+		Tty:        true,
+		OpenStdin:  true,
+		Labels: map[string]string{
+			"ssh2docker": "",
+			"user":       c.Config.RemoteUser,
+			"image":      c.Config.ImageName,
+		},
+	}
+
+	resp, err := c.Server.Docker.ContainerCreate(ctx, config, &container.HostConfig{}, nil, nil, "")
+	if err != nil {
+		return "", fmt.Errorf("ContainerCreate failed: %v", err)
+	}
+
+	if err := c.Server.Docker.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return "", fmt.Errorf("ContainerStart failed: %v", err)
+	}
+	return resp.ID, nil
+}
+
+// resolveContainer finds a joinable container for the client or creates a
+// new one when none exists (or Server.NoJoin is set).
+func (c *Client) resolveContainer(ctx context.Context) (string, error) {
+	id, err := c.findJoinableContainer(ctx)
+	if err != nil {
+		return "", err
+	}
+	if id != "" {
+		return id, nil
+	}
+	return c.createContainer(ctx)
+}
+
+// attachExec creates and attaches an exec session running cmd inside
+// container id, replacing the `docker exec` shell-out.
+func (c *Client) attachExec(ctx context.Context, id string, cmd []string, tty bool) (types.HijackedResponse, string, error) {
+	created, err := c.Server.Docker.ContainerExecCreate(ctx, id, types.ExecConfig{
+		Cmd:          cmd,
+		Env:          c.Config.Env.List(),
+		Tty:          tty,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return types.HijackedResponse{}, "", fmt.Errorf("ContainerExecCreate failed: %v", err)
+	}
+
+	resp, err := c.Server.Docker.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{Tty: tty})
+	if err != nil {
+		return types.HijackedResponse{}, "", fmt.Errorf("ContainerExecAttach failed: %v", err)
+	}
+	return resp, created.ID, nil
+}
+
+// runContainerShell attaches an interactive TTY exec session to the
+// client's container and streams it directly over channel, cancelling the
+// exec's context once either side disconnects.
+func (c *Client) runContainerShell(channel ssh.Channel) error {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	id, err := c.resolveContainer(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	shell := c.Server.DefaultShell
+	if c.Config.EntryPoint != "" {
+		shell = c.Config.EntryPoint
+	}
+
+	hijacked, execID, err := c.attachExec(ctx, id, []string{shell}, true)
+	if err != nil {
+		cancel()
+		return err
+	}
+	c.ExecID = execID
+
+	var once sync.Once
+	closeFn := func() {
+		hijacked.Close()
+		channel.Close()
+		cancel()
+		log.Infof("Received disconnect from %s: disconnected by user", c.ClientID)
+	}
+
+	go func() {
+		io.Copy(channel, hijacked.Reader)
+		once.Do(closeFn)
+	}()
+
+	go func() {
+		io.Copy(hijacked.Conn, channel)
+		once.Do(closeFn)
+	}()
+
+	return nil
+}
+
+// runContainerExec runs a one-shot command inside the client's container
+// and propagates its exit status back to channel, demultiplexing stdout
+// from stderr since there's no shared PTY.
+func (c *Client) runContainerExec(channel ssh.Channel, command string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	id, err := c.resolveContainer(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	shell := c.Server.DefaultShell
+	if c.Config.EntryPoint != "" {
+		shell = c.Config.EntryPoint
+	}
+
+	hijacked, execID, err := c.attachExec(ctx, id, []string{shell, "-c", command}, false)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	go func() {
+		io.Copy(hijacked.Conn, channel)
+		hijacked.CloseWrite()
+	}()
+
+	go func() {
+		defer cancel()
+		stdcopy.StdCopy(channel, channel.Stderr(), hijacked.Reader)
+		hijacked.Close()
+
+		status := uint32(0)
+		if inspect, err := c.Server.Docker.ContainerExecInspect(ctx, execID); err != nil {
+			log.Warnf("ContainerExecInspect failed: %v", err)
+		} else {
+			status = uint32(inspect.ExitCode)
+		}
+
+		channel.SendRequest("exit-status", false, ssh.Marshal(&struct{ Status uint32 }{Status: status}))
+		channel.Close()
+	}()
+
+	return nil
+}
+
+// resizeExec resizes the active exec session's TTY in response to a
+// "window-change" request.
+func (c *Client) resizeExec(w, h uint32) {
+	if c.ExecID == "" || c.Config.IsLocal {
+		return
+	}
+
+	err := c.Server.Docker.ContainerExecResize(context.Background(), c.ExecID, types.ResizeOptions{
+		Width:  uint(w),
+		Height: uint(h),
+	})
+	if err != nil {
+		log.Debugf("ContainerExecResize failed: %v", err)
+	}
+}
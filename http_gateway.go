@@ -0,0 +1,299 @@
+package ssh2docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/hashicorp/yamux"
+	"golang.org/x/crypto/ssh"
+)
+
+// httpGatewayRequestType is the channel request a client issues to switch
+// a session channel into an HTTP-over-SSH control plane.
+const httpGatewayRequestType = "ssh2docker-http@moul.io"
+
+// HandleHTTPGateway wraps channel in a yamux server session and serves the
+// container introspection API over it. Authentication is implicit (the SSH
+// connection already authenticated the user); authorization is scoped to
+// containers labeled with this client's RemoteUser.
+func (c *Client) HandleHTTPGateway(channel ssh.Channel) {
+	session, err := yamux.Server(channel, nil)
+	if err != nil {
+		log.Warnf("yamux.Server failed: %v", err)
+		channel.Close()
+		return
+	}
+
+	gw := &httpGateway{client: c}
+	if err := http.Serve(session, gw.mux()); err != nil {
+		log.Debugf("http gateway closed for %s: %v", c.ClientID, err)
+	}
+}
+
+// DialHTTPGateway is the client-side counterpart of HandleHTTPGateway: it
+// opens a session channel, switches it into HTTP gateway mode, and returns
+// an *http.Client that dials into the yamux session instead of a TCP
+// socket. Callers still address paths like "http://ssh2docker/containers".
+func DialHTTPGateway(conn *ssh.Client) (*http.Client, error) {
+	channel, requests, err := conn.OpenChannel("session", nil)
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(requests)
+
+	ok, err := channel.SendRequest(httpGatewayRequestType, true, nil)
+	if err != nil {
+		channel.Close()
+		return nil, err
+	}
+	if !ok {
+		channel.Close()
+		return nil, fmt.Errorf("server rejected %s request", httpGatewayRequestType)
+	}
+
+	session, err := yamux.Client(channel, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return session.Open()
+			},
+		},
+	}, nil
+}
+
+// httpGateway holds the client context shared by every request handler.
+type httpGateway struct {
+	client *Client
+}
+
+func (gw *httpGateway) mux() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers", gw.listContainers)
+	mux.HandleFunc("/containers/", gw.containerRoutes)
+	mux.HandleFunc("/exec", gw.exec)
+	return mux
+}
+
+// ownLabelFilter restricts Docker API calls to containers owned by the
+// connected client's RemoteUser.
+func (gw *httpGateway) ownLabelFilter() filters.Args {
+	f := filters.NewArgs()
+	f.Add("label", "ssh2docker")
+	f.Add("label", fmt.Sprintf("user=%s", gw.client.Config.RemoteUser))
+	return f
+}
+
+// authorized checks that container id belongs to the connected client.
+func (gw *httpGateway) authorized(ctx context.Context, id string) bool {
+	info, err := gw.client.Server.Docker.ContainerInspect(ctx, id)
+	if err != nil || info.Config == nil {
+		return false
+	}
+	return info.Config.Labels["user"] == gw.client.Config.RemoteUser
+}
+
+func (gw *httpGateway) listContainers(w http.ResponseWriter, r *http.Request) {
+	containers, err := gw.client.Server.Docker.ContainerList(r.Context(), types.ContainerListOptions{Filters: gw.ownLabelFilter()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(containers)
+}
+
+func (gw *httpGateway) containerRoutes(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/containers/")
+	parts := strings.SplitN(rest, "/", 2)
+	if parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	id := parts[0]
+
+	if !gw.authorized(r.Context(), id) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case parts[1] == "logs":
+		gw.logs(w, r, id)
+	case parts[1] == "stats":
+		gw.stats(w, r, id)
+	case strings.HasPrefix(parts[1], "files/"):
+		gw.files(w, r, id, strings.TrimPrefix(parts[1], "files/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (gw *httpGateway) logs(w http.ResponseWriter, r *http.Request, id string) {
+	reader, err := gw.client.Server.Docker.ContainerLogs(r.Context(), id, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			w.Write(buf[:n])
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (gw *httpGateway) stats(w http.ResponseWriter, r *http.Request, id string) {
+	stats, err := gw.client.Server.Docker.ContainerStats(r.Context(), id, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer stats.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	io.Copy(w, stats.Body)
+}
+
+func (gw *httpGateway) files(w http.ResponseWriter, r *http.Request, id, filePath string) {
+	switch r.Method {
+	case http.MethodGet:
+		gw.getFile(w, r, id, filePath)
+	case http.MethodPut:
+		gw.putFile(w, r, id, filePath)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getFile extracts a single file out of the tar stream docker cp returns.
+func (gw *httpGateway) getFile(w http.ResponseWriter, r *http.Request, id, filePath string) {
+	reader, _, err := gw.client.Server.Docker.CopyFromContainer(r.Context(), id, filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	if _, err := tr.Next(); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	io.Copy(w, tr)
+}
+
+// putFile wraps the request body in a tar archive and docker-cp's it into
+// the container at filePath.
+func (gw *httpGateway) putFile(w http.ResponseWriter, r *http.Request, id, filePath string) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	header := &tar.Header{
+		Name: path.Base(filePath),
+		Mode: 0644,
+		Size: int64(len(content)),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tw.Write(content); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tw.Close()
+
+	err = gw.client.Server.Docker.CopyToContainer(r.Context(), id, path.Dir(filePath), &buf, types.CopyToContainerOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// execRequest is the body of a POST /exec call.
+type execRequest struct {
+	Container string `json:"container"`
+	Cmd       string `json:"cmd"`
+}
+
+type execResponse struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+}
+
+func (gw *httpGateway) exec(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req execRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !gw.authorized(r.Context(), req.Container) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	shell := gw.client.Server.DefaultShell
+	if gw.client.Config.EntryPoint != "" {
+		shell = gw.client.Config.EntryPoint
+	}
+
+	hijacked, execID, err := gw.client.attachExec(r.Context(), req.Container, []string{shell, "-c", req.Cmd}, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer hijacked.Close()
+
+	var stdout, stderr bytes.Buffer
+	stdcopy.StdCopy(&stdout, &stderr, hijacked.Reader)
+
+	if inspect, err := gw.client.Server.Docker.ContainerExecInspect(r.Context(), execID); err == nil && inspect.ExitCode != 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+
+	json.NewEncoder(w).Encode(execResponse{Stdout: stdout.String(), Stderr: stderr.String()})
+}
@@ -0,0 +1,144 @@
+package ssh2docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/pkg/stdcopy"
+	"golang.org/x/crypto/ssh"
+)
+
+// channelOpenDirectMsg is the RFC 4254 §7.2 payload of a "direct-tcpip"
+// channel open request.
+type channelOpenDirectMsg struct {
+	DestAddr   string
+	DestPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// HandleDirectTCPIP handles a "direct-tcpip" channel, proxying the stream
+// to a TCP endpoint reachable from inside the client's container. This is
+// what backs `ssh -L`.
+func (c *Client) HandleDirectTCPIP(newChannel ssh.NewChannel) error {
+	if !c.Server.AllowPortForward {
+		newChannel.Reject(ssh.Prohibited, "port forwarding is disabled")
+		return nil
+	}
+
+	var msg channelOpenDirectMsg
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &msg); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "invalid direct-tcpip payload")
+		return nil
+	}
+
+	if !c.forwardPortAllowed(msg.DestPort) {
+		log.Warnf("Rejected forward to %s:%d for %s: port not allowed", msg.DestAddr, msg.DestPort, c.ClientID)
+		newChannel.Reject(ssh.Prohibited, "destination port not allowed")
+		return nil
+	}
+
+	target, err := c.dialForward(msg.DestAddr, msg.DestPort)
+	if err != nil {
+		log.Warnf("direct-tcpip to %s:%d failed: %v", msg.DestAddr, msg.DestPort, err)
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return nil
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		target.Close()
+		log.Errorf("newChannel.Accept failed: %v", err)
+		return err
+	}
+	go ssh.DiscardRequests(requests)
+
+	go func() {
+		var once sync.Once
+		closeBoth := func() {
+			channel.Close()
+			target.Close()
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			io.Copy(target, channel)
+			once.Do(closeBoth)
+			wg.Done()
+		}()
+		go func() {
+			io.Copy(channel, target)
+			once.Do(closeBoth)
+			wg.Done()
+		}()
+		wg.Wait()
+	}()
+
+	return nil
+}
+
+// forwardPortAllowed checks the destination port against the client's
+// AllowedForwardPorts allow-list. An empty list allows any port.
+func (c *Client) forwardPortAllowed(port uint32) bool {
+	if len(c.Config.AllowedForwardPorts) == 0 {
+		return true
+	}
+
+	portStr := fmt.Sprintf("%d", port)
+	for _, allowed := range c.Config.AllowedForwardPorts {
+		if allowed == portStr {
+			return true
+		}
+	}
+	return false
+}
+
+// dialForward reaches destHost:destPort from inside the client's container,
+// via an `nc` exec session in its network namespace, so any destination
+// (including "localhost", which resolves against the container's own
+// loopback) is reached the way the connecting user would see it. Server.
+// LocalUser, or a client with no resolvable container, dials directly from
+// the host instead.
+func (c *Client) dialForward(destHost string, destPort uint32) (io.ReadWriteCloser, error) {
+	ctx := context.Background()
+
+	ctr, err := c.findJoinableContainer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ctr == "" || c.Config.IsLocal {
+		return net.DialTimeout("tcp", fmt.Sprintf("%s:%d", destHost, destPort), 5*time.Second)
+	}
+
+	return c.dialViaContainerNetcat(ctx, ctr, destHost, destPort)
+}
+
+// dialViaContainerNetcat proxies the connection through an `nc` exec session
+// inside ctr. The exec runs without a TTY, so no echo or newline translation
+// alters the forwarded data; its stdcopy-framed output is demultiplexed and
+// only nc's stdout is returned as the read side of the stream.
+func (c *Client) dialViaContainerNetcat(ctx context.Context, ctr, destHost string, destPort uint32) (io.ReadWriteCloser, error) {
+	hijacked, _, err := c.attachExec(ctx, ctr, []string{"nc", destHost, fmt.Sprintf("%d", destPort)}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, stdoutWriter := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(stdoutWriter, ioutil.Discard, hijacked.Reader)
+		stdoutWriter.CloseWithError(err)
+	}()
+
+	return struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{stdout, hijacked.Conn, hijacked.Conn}, nil
+}
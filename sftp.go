@@ -0,0 +1,327 @@
+package ssh2docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// HandleSFTP serves the "sftp" subsystem on channel, translating every
+// Filelist/Fileread/Filewrite/Filecmd operation into docker exec calls
+// against the client's joinable container (the same one `shell` resolves),
+// or into plain shell-outs when the client is Server.LocalUser.
+func (c *Client) HandleSFTP(channel ssh.Channel) {
+	ctr := ""
+	if !c.Config.IsLocal {
+		id, err := c.resolveContainer(context.Background())
+		if err != nil {
+			log.Warnf("sftp: resolveContainer failed: %v", err)
+			channel.Close()
+			return
+		}
+		ctr = id
+	}
+
+	handlers := sftp.Handlers{
+		FileGet:  &dockerSFTPHandler{client: c, ctr: ctr},
+		FilePut:  &dockerSFTPHandler{client: c, ctr: ctr},
+		FileCmd:  &dockerSFTPHandler{client: c, ctr: ctr},
+		FileList: &dockerSFTPHandler{client: c, ctr: ctr},
+	}
+
+	server := sftp.NewRequestServer(channel, handlers)
+	if err := server.Serve(); err != nil {
+		log.Debugf("sftp server closed: %v", err)
+	}
+	server.Close()
+	channel.Close()
+}
+
+// dockerSFTPHandler implements sftp.FileReader/FileWriter/FileCmder/
+// FileLister against the resolved container, through the Docker Engine
+// API for the container case and through /bin/bash for Server.LocalUser.
+type dockerSFTPHandler struct {
+	client *Client
+	ctr    string
+}
+
+// resolvePath joins p onto SFTPRootPath and confines the result to that
+// root: filepath.Join alone would clean away a leading ".." and let a path
+// like "/../etc/passwd" escape it, so we also verify the cleaned result is
+// still rooted under root.
+func (h *dockerSFTPHandler) resolvePath(p string) string {
+	root := h.client.Config.SFTPRootPath
+	if root == "" {
+		return p
+	}
+
+	resolved := filepath.Join(root, p)
+	if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return root
+	}
+	return resolved
+}
+
+// run executes args and returns its output: inside h.ctr via
+// ContainerExecCreate/Attach for the container case (plain argv, no shell
+// involved), returning stdout only, with stderr carried in the error on a
+// non-zero exit; or via `/bin/bash -c` for Server.LocalUser, where each arg
+// is individually shell-quoted before being joined into the command string,
+// returning combined stdout/stderr.
+func (h *dockerSFTPHandler) run(args ...string) ([]byte, error) {
+	return h.runWithStdin(nil, args...)
+}
+
+// runWithStdin is like run but also feeds stdin to the command, used by
+// dockerFileWriter to stream a file's contents to `tee`.
+func (h *dockerSFTPHandler) runWithStdin(stdin []byte, args ...string) ([]byte, error) {
+	if h.client.Config.IsLocal {
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = shellQuote(arg)
+		}
+		cmd := exec.Command("/bin/bash", "-c", strings.Join(quoted, " "))
+		cmd.Stdin = bytes.NewReader(stdin)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return out, fmt.Errorf("%v: %s", err, out)
+		}
+		return out, nil
+	}
+
+	ctx := context.Background()
+	hijacked, execID, err := h.client.attachExec(ctx, h.ctr, args, false)
+	if err != nil {
+		return nil, err
+	}
+	defer hijacked.Close()
+
+	go func() {
+		io.Copy(hijacked.Conn, bytes.NewReader(stdin))
+		hijacked.CloseWrite()
+	}()
+
+	var stdout, stderr bytes.Buffer
+	stdcopy.StdCopy(&stdout, &stderr, hijacked.Reader)
+
+	if inspect, err := h.client.Server.Docker.ContainerExecInspect(ctx, execID); err == nil && inspect.ExitCode != 0 {
+		return stdout.Bytes(), fmt.Errorf("exit status %d: %s", inspect.ExitCode, stderr.Bytes())
+	}
+	return stdout.Bytes(), nil
+}
+
+func (h *dockerSFTPHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	path := h.resolvePath(r.Filepath)
+	buf, err := h.run("cat", path)
+	if err != nil {
+		return nil, fmt.Errorf("sftp read %s failed: %v", path, err)
+	}
+	return bytes.NewReader(buf), nil
+}
+
+func (h *dockerSFTPHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return &dockerFileWriter{handler: h, path: h.resolvePath(r.Filepath)}, nil
+}
+
+func (h *dockerSFTPHandler) Filecmd(r *sftp.Request) error {
+	path := h.resolvePath(r.Filepath)
+
+	var err error
+	switch r.Method {
+	case "Mkdir":
+		_, err = h.run("mkdir", "-p", path)
+	case "Rmdir":
+		_, err = h.run("rmdir", path)
+	case "Remove":
+		_, err = h.run("rm", "-f", path)
+	case "Rename":
+		_, err = h.run("mv", path, h.resolvePath(r.Target))
+	default:
+		return fmt.Errorf("sftp: unsupported Filecmd method %q", r.Method)
+	}
+
+	if err != nil {
+		return fmt.Errorf("sftp %s %s failed: %v", r.Method, path, err)
+	}
+	return nil
+}
+
+func (h *dockerSFTPHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	path := h.resolvePath(r.Filepath)
+
+	switch r.Method {
+	case "List":
+		entries, err := h.listDir(path)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt(entries), nil
+	case "Stat", "Lstat":
+		info, err := h.stat(path)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt([]os.FileInfo{info}), nil
+	default:
+		return nil, fmt.Errorf("sftp: unsupported Filelist method %q", r.Method)
+	}
+}
+
+// listDir runs `ls -la` inside the container and parses its output into
+// os.FileInfo entries.
+func (h *dockerSFTPHandler) listDir(path string) ([]os.FileInfo, error) {
+	buf, err := h.run("ls", "-la", path)
+	if err != nil {
+		return nil, fmt.Errorf("sftp list %s failed: %v", path, err)
+	}
+
+	var entries []os.FileInfo
+	for _, line := range strings.Split(string(buf), "\n") {
+		info, ok := parseLsLine(line)
+		if ok {
+			entries = append(entries, info)
+		}
+	}
+	return entries, nil
+}
+
+func (h *dockerSFTPHandler) stat(path string) (os.FileInfo, error) {
+	buf, err := h.run("stat", "-c", "%n|%s|%f|%Y", path)
+	if err != nil {
+		return nil, fmt.Errorf("sftp stat %s failed: %v", path, err)
+	}
+
+	fields := strings.Split(strings.TrimSpace(string(buf)), "|")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("sftp stat %s: unexpected output %q", path, buf)
+	}
+	size, _ := strconv.ParseInt(fields[1], 10, 64)
+	mtime, _ := strconv.ParseInt(fields[3], 10, 64)
+	rawMode, _ := strconv.ParseUint(fields[2], 16, 32)
+	isDir := rawMode&0170000 == 0040000
+
+	mode := os.FileMode(rawMode & 0777)
+	if isDir {
+		mode |= os.ModeDir
+	}
+
+	return &dockerFileInfo{
+		name:    filepath.Base(path),
+		size:    size,
+		mode:    mode,
+		modTime: time.Unix(mtime, 0),
+		isDir:   isDir,
+	}, nil
+}
+
+// dockerFileWriter buffers writes in memory and flushes them through
+// `docker exec <ctr> tee <path>` once the SFTP request closes it.
+type dockerFileWriter struct {
+	handler *dockerSFTPHandler
+	path    string
+	mu      sync.Mutex
+	buf     []byte
+}
+
+func (w *dockerFileWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	end := int(off) + len(p)
+	if end > len(w.buf) {
+		grown := make([]byte, end)
+		copy(grown, w.buf)
+		w.buf = grown
+	}
+	copy(w.buf[off:], p)
+	return len(p), nil
+}
+
+func (w *dockerFileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.handler.runWithStdin(w.buf, "tee", w.path); err != nil {
+		return fmt.Errorf("sftp write %s failed: %v", w.path, err)
+	}
+	return nil
+}
+
+// dockerFileInfo is a minimal os.FileInfo backed by parsed `ls`/`stat`
+// output, since we have no local os.Stat to call.
+type dockerFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	isDir   bool
+}
+
+func (i *dockerFileInfo) Name() string       { return i.name }
+func (i *dockerFileInfo) Size() int64        { return i.size }
+func (i *dockerFileInfo) Mode() os.FileMode  { return i.mode }
+func (i *dockerFileInfo) ModTime() time.Time { return i.modTime }
+func (i *dockerFileInfo) IsDir() bool        { return i.isDir }
+func (i *dockerFileInfo) Sys() interface{}   { return nil }
+
+// listerAt adapts a plain []os.FileInfo slice to sftp.ListerAt.
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// parseLsLine parses one line of `ls -la` output into an os.FileInfo,
+// skipping the "total" header and "." / ".." entries.
+func parseLsLine(line string) (os.FileInfo, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 9 || fields[0] == "total" {
+		return nil, false
+	}
+	name := strings.Join(fields[8:], " ")
+	if name == "." || name == ".." {
+		return nil, false
+	}
+
+	size, _ := strconv.ParseInt(fields[4], 10, 64)
+	return &dockerFileInfo{
+		name:  name,
+		size:  size,
+		isDir: strings.HasPrefix(fields[0], "d"),
+		mode:  parseLsMode(fields[0]),
+	}, true
+}
+
+func parseLsMode(perms string) os.FileMode {
+	var mode os.FileMode
+	if strings.HasPrefix(perms, "d") {
+		mode |= os.ModeDir
+	}
+	return mode
+}
+
+// shellQuote wraps an argument in single quotes for safe interpolation into
+// the `/bin/bash -c` command line run for Server.LocalUser.
+func shellQuote(path string) string {
+	return "'" + strings.Replace(path, "'", `'\''`, -1) + "'"
+}